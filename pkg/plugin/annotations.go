@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/client"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// annotationQueryType is the queryModel.QueryType value panels use to ask
+// for device connection/disconnection events instead of telemetry.
+const annotationQueryType = "annotations"
+
+// queryAnnotations returns a frame shaped for Grafana's annotation contract
+// (time, timeEnd, title, text, tags), built from device connection history.
+// When qm.Device is empty the whole realm is scanned instead of one device.
+func (d *AppEngineDatasource) queryAnnotations(ctx context.Context, qm queryModel, timeRange backend.TimeRange) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	var events []deviceLifecycleEvent
+	var err error
+	if qm.Device != "" {
+		events, err = d.deviceLifecycleEvents(ctx, qm.Device, timeRange)
+	} else {
+		events, err = d.realmLifecycleEvents(ctx, timeRange)
+	}
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	times := make([]time.Time, 0, len(events))
+	timeEnds := make([]time.Time, 0, len(events))
+	titles := make([]string, 0, len(events))
+	texts := make([]string, 0, len(events))
+	tags := make([]string, 0, len(events))
+
+	for _, e := range events {
+		times = append(times, e.Time)
+		timeEnds = append(timeEnds, e.Time)
+		titles = append(titles, e.Title)
+		texts = append(texts, e.Text)
+		tags = append(tags, e.Tag)
+	}
+
+	frame := data.NewFrame("annotations",
+		data.NewField("time", nil, times),
+		data.NewField("timeEnd", nil, timeEnds),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// deviceLifecycleEvent is one connection or disconnection annotation entry.
+type deviceLifecycleEvent struct {
+	Time  time.Time
+	Title string
+	Text  string
+	Tag   string
+}
+
+func (d *AppEngineDatasource) deviceLifecycleEvents(ctx context.Context, deviceID string, timeRange backend.TimeRange) ([]deviceLifecycleEvent, error) {
+	_, endSpan := startSpan(ctx, "astarte.get_device", attribute.String("realm", d.realm), attribute.String("device_id", deviceID))
+	device, err := d.astarteAPIClient.AppEngine.GetDevice(d.realm, deviceID, client.AstarteDeviceID)
+	endSpan(err)
+	if err != nil {
+		log.DefaultLogger.Error("Cannot get device for annotations", "error", err, "device_id", deviceID)
+		return nil, err
+	}
+
+	return deviceToEvents(deviceID, device, timeRange), nil
+}
+
+func (d *AppEngineDatasource) realmLifecycleEvents(ctx context.Context, timeRange backend.TimeRange) ([]deviceLifecycleEvent, error) {
+	paginator, err := d.astarteAPIClient.AppEngine.ListDevicesPaginator(d.realm, 100, client.AstarteDeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []deviceLifecycleEvent
+	for ok := true; ok; ok = paginator.HasNextPage() {
+		_, endSpan := startSpan(ctx, "astarte.list_devices_page", attribute.String("realm", d.realm))
+		page, err := paginator.GetNextPage()
+		endSpan(err)
+		if err != nil {
+			log.DefaultLogger.Error("Next page paginator error while listing devices", "error", err)
+			return nil, err
+		}
+
+		for _, device := range page {
+			events = append(events, deviceToEvents(device.DeviceID, device, timeRange)...)
+		}
+	}
+
+	return events, nil
+}
+
+func deviceToEvents(deviceID string, device client.Device, timeRange backend.TimeRange) []deviceLifecycleEvent {
+	var events []deviceLifecycleEvent
+
+	if inRange(device.LastConnection, timeRange) {
+		events = append(events, deviceLifecycleEvent{
+			Time:  device.LastConnection,
+			Title: "Device connected",
+			Text:  deviceID,
+			Tag:   "connected",
+		})
+	}
+	if inRange(device.LastDisconnection, timeRange) {
+		events = append(events, deviceLifecycleEvent{
+			Time:  device.LastDisconnection,
+			Title: "Device disconnected",
+			Text:  deviceID,
+			Tag:   "disconnected",
+		})
+	}
+
+	return events
+}
+
+func inRange(t time.Time, timeRange backend.TimeRange) bool {
+	if t.IsZero() {
+		return false
+	}
+	return !t.Before(timeRange.From) && !t.After(timeRange.To)
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import "time"
+
+// downsampleBucket accumulates one fixed-width window of samples online, so
+// memory stays O(maxDataPoints) regardless of how many samples the paginator
+// actually walks through.
+type downsampleBucket struct {
+	has   bool
+	start time.Time
+	sum   float64
+	min   float64
+	max   float64
+	last  float64
+	count int64
+}
+
+// downsampler buckets timestamped float64 samples into maxDataPoints
+// fixed-width windows and reduces each window with the configured
+// aggregator (avg, min, max, last or count).
+type downsampler struct {
+	kind    string
+	from    time.Time
+	width   time.Duration
+	buckets []downsampleBucket
+}
+
+func newDownsampler(kind string, from, to time.Time, maxDataPoints int64) *downsampler {
+	if maxDataPoints <= 0 {
+		maxDataPoints = 1
+	}
+
+	width := to.Sub(from) / time.Duration(maxDataPoints)
+	if width <= 0 {
+		width = time.Nanosecond
+	}
+
+	return &downsampler{
+		kind:    kind,
+		from:    from,
+		width:   width,
+		buckets: make([]downsampleBucket, maxDataPoints),
+	}
+}
+
+func (ds *downsampler) bucketIndex(t time.Time) int {
+	idx := int(t.Sub(ds.from) / ds.width)
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(ds.buckets) {
+		idx = len(ds.buckets) - 1
+	}
+	return idx
+}
+
+func (ds *downsampler) add(t time.Time, v float64) {
+	idx := ds.bucketIndex(t)
+	b := &ds.buckets[idx]
+
+	if !b.has {
+		b.has = true
+		b.start = ds.from.Add(time.Duration(idx) * ds.width)
+		b.min = v
+		b.max = v
+	}
+
+	b.sum += v
+	b.count++
+	b.last = v
+	if v < b.min {
+		b.min = v
+	}
+	if v > b.max {
+		b.max = v
+	}
+}
+
+// results flushes every non-empty bucket, in chronological order, reduced
+// with the configured aggregator.
+func (ds *downsampler) results() ([]time.Time, []float64) {
+	times := []time.Time{}
+	values := []float64{}
+
+	for _, b := range ds.buckets {
+		if !b.has {
+			continue
+		}
+
+		times = append(times, b.start)
+		switch ds.kind {
+		case "min":
+			values = append(values, b.min)
+		case "max":
+			values = append(values, b.max)
+		case "last":
+			values = append(values, b.last)
+		case "count":
+			values = append(values, float64(b.count))
+		default: // "avg"
+			values = append(values, b.sum/float64(b.count))
+		}
+	}
+
+	return times, values
+}
+
+// bucketWidth reports the effective interval the aggregator is using, so the
+// caller can advertise it on the frame's meta for panels to render correctly.
+func (ds *downsampler) bucketWidth() time.Duration {
+	return ds.width
+}
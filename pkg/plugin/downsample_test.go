@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsamplerBucketsByAggregator(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Minute)
+
+	tests := []struct {
+		name string
+		kind string
+		want float64
+	}{
+		{"avg", "avg", 2},
+		{"min", "min", 1},
+		{"max", "max", 3},
+		{"last", "last", 3},
+		{"count", "count", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newDownsampler(tt.kind, from, to, 1)
+			ds.add(from, 1)
+			ds.add(from.Add(time.Minute), 2)
+			ds.add(from.Add(2*time.Minute), 3)
+
+			times, values := ds.results()
+			if len(times) != 1 || len(values) != 1 {
+				t.Fatalf("expected a single bucket, got %d", len(values))
+			}
+			if values[0] != tt.want {
+				t.Errorf("got %v, want %v", values[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestDownsamplerSplitsIntoMaxDataPointsBuckets(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(4 * time.Hour)
+
+	ds := newDownsampler("avg", from, to, 4)
+	ds.add(from, 1)
+	ds.add(from.Add(time.Hour), 2)
+	ds.add(from.Add(2*time.Hour), 3)
+	ds.add(from.Add(3*time.Hour), 4)
+
+	times, values := ds.results()
+	if len(times) != 4 || len(values) != 4 {
+		t.Fatalf("expected 4 buckets, got %d", len(values))
+	}
+	for i, want := range []float64{1, 2, 3, 4} {
+		if values[i] != want {
+			t.Errorf("bucket %d: got %v, want %v", i, values[i], want)
+		}
+	}
+}
+
+func TestDownsamplerEmptyBucketsAreOmitted(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(4 * time.Hour)
+
+	ds := newDownsampler("avg", from, to, 4)
+	ds.add(from, 1)
+	ds.add(from.Add(3*time.Hour), 4)
+
+	times, values := ds.results()
+	if len(times) != 2 || len(values) != 2 {
+		t.Fatalf("expected only the 2 populated buckets, got %d", len(values))
+	}
+}
+
+func TestDownsamplerClampsOutOfRangeTimestampsToEdgeBuckets(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	ds := newDownsampler("count", from, to, 2)
+	ds.add(from.Add(-time.Hour), 1)
+	ds.add(to.Add(time.Hour), 1)
+
+	times, values := ds.results()
+	if len(times) != 2 {
+		t.Fatalf("expected both edge buckets populated, got %d", len(times))
+	}
+	if values[0] != 1 || values[1] != 1 {
+		t.Errorf("got %v, want each edge bucket to hold exactly one sample", values)
+	}
+}
+
+func TestNewDownsamplerGuardsZeroMaxDataPoints(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	ds := newDownsampler("avg", from, to, 0)
+	if len(ds.buckets) != 1 {
+		t.Fatalf("expected maxDataPoints <= 0 to fall back to a single bucket, got %d", len(ds.buckets))
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+
+	"github.com/astarte-platform/astarte-go/client"
+	"github.com/astarte-platform/astarte-go/interfaces"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxConcurrentDeviceQueries bounds how many devices a fan-out query reads
+// from concurrently, so a fleet dashboard with hundreds of devices doesn't
+// open hundreds of paginators against Astarte at once.
+const maxConcurrentDeviceQueries = 8
+
+// resolveDevices turns a queryModel into the concrete device list a query
+// should run against: an explicit Devices list, the members of DeviceGroup,
+// or the single legacy Device as a one-element fallback.
+func (d *AppEngineDatasource) resolveDevices(ctx context.Context, qm queryModel) ([]string, error) {
+	if len(qm.Devices) > 0 {
+		return qm.Devices, nil
+	}
+
+	if qm.DeviceGroup != "" {
+		_, endSpan := startSpan(ctx, "astarte.get_group_devices", attribute.String("realm", d.realm), attribute.String("group", qm.DeviceGroup))
+		members, err := d.astarteAPIClient.AppEngine.ListGroupDevices(d.realm, qm.DeviceGroup)
+		endSpan(err)
+		if err != nil {
+			return nil, err
+		}
+		return members, nil
+	}
+
+	return []string{qm.Device}, nil
+}
+
+// queryFanOut reads every device's paginator concurrently, bounded by
+// maxConcurrentDeviceQueries and cancellable via ctx, and merges the results
+// into one response with a device_id label on every non-Time field so
+// Grafana's legend and transformations treat them as a series set.
+func (d *AppEngineDatasource) queryFanOut(ctx context.Context, devices []string, interfaceDoc interfaces.AstarteInterface, qm queryModel, query backend.DataQuery) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	type deviceResult struct {
+		device string
+		frames data.Frames
+		err    error
+	}
+
+	results := make([]deviceResult, len(devices))
+	sem := make(chan struct{}, maxConcurrentDeviceQueries)
+	var wg sync.WaitGroup
+
+	for i, device := range devices {
+		wg.Add(1)
+		go func(i int, device string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i] = deviceResult{device: device, err: ctx.Err()}
+				return
+			}
+
+			dr := d.queryOneDevice(ctx, device, interfaceDoc, qm, query)
+			if dr.Error != nil {
+				results[i] = deviceResult{device: device, err: dr.Error}
+				return
+			}
+
+			labelFramesWithDevice(dr.Frames, device)
+			results[i] = deviceResult{device: device, frames: dr.Frames}
+		}(i, device)
+	}
+
+	wg.Wait()
+
+	var firstErr error
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			log.DefaultLogger.Warn("Fan-out query failed for device, skipping its series", "device", r.device, "error", r.err)
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			failed++
+			continue
+		}
+		response.Frames = append(response.Frames, r.frames...)
+	}
+
+	// A handful of failures among many devices still produces a usable
+	// (partial) panel, so only warnings above are enough. But if every
+	// device failed - a stale path, an expired JWT - silently returning
+	// zero frames and no error would render as an empty "No data" panel
+	// instead of surfacing what actually happened.
+	if failed > 0 && failed == len(results) {
+		response.Error = firstErr
+	}
+
+	return response
+}
+
+func (d *AppEngineDatasource) queryOneDevice(ctx context.Context, device string, interfaceDoc interfaces.AstarteInterface, qm queryModel, query backend.DataQuery) backend.DataResponse {
+	paginator, err := d.astarteAPIClient.AppEngine.GetDatastreamsTimeWindowPaginator(d.realm, device, client.AstarteDeviceID, qm.InterfaceName,
+		qm.Path, query.TimeRange.From, query.TimeRange.To, client.AscendingOrder)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+
+	if interfaceDoc.Aggregation == interfaces.ObjectAggregation {
+		return queryObjectAggregate(ctx, paginator, interfaceDoc, qm, query)
+	}
+	return queryIndividual(ctx, paginator, interfaceDoc, qm, query)
+}
+
+func labelFramesWithDevice(frames data.Frames, device string) {
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if field.Name == "Time" {
+				continue
+			}
+			if field.Labels == nil {
+				field.Labels = data.Labels{}
+			}
+			field.Labels["device_id"] = device
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestLabelFramesWithDevice(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("Time", nil, []time.Time{time.Now()}),
+		data.NewField("Value", nil, []float64{1}),
+	)
+
+	labelFramesWithDevice(data.Frames{frame}, "device0")
+
+	if frame.Fields[0].Labels != nil {
+		t.Errorf("Time field should not be labelled, got %v", frame.Fields[0].Labels)
+	}
+	if got := frame.Fields[1].Labels["device_id"]; got != "device0" {
+		t.Errorf("Value field device_id label = %q, want %q", got, "device0")
+	}
+}
+
+func TestLabelFramesWithDevicePreservesExistingLabels(t *testing.T) {
+	field := data.NewField("Value", data.Labels{"unit": "celsius"}, []float64{1})
+	frame := data.NewFrame("response", field)
+
+	labelFramesWithDevice(data.Frames{frame}, "device0")
+
+	if got := field.Labels["unit"]; got != "celsius" {
+		t.Errorf("expected pre-existing label to survive, got %v", field.Labels)
+	}
+	if got := field.Labels["device_id"]; got != "device0" {
+		t.Errorf("device_id label = %q, want %q", got, "device0")
+	}
+}
+
+func TestResolveDevicesFallsBackToSingleDevice(t *testing.T) {
+	d := &AppEngineDatasource{}
+	devices, err := d.resolveDevices(nil, queryModel{Device: "device0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 1 || devices[0] != "device0" {
+		t.Errorf("got %v, want [device0]", devices)
+	}
+}
+
+func TestResolveDevicesPrefersExplicitDevicesList(t *testing.T) {
+	d := &AppEngineDatasource{}
+	devices, err := d.resolveDevices(nil, queryModel{Device: "device0", Devices: []string{"device1", "device2"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 2 || devices[0] != "device1" || devices[1] != "device2" {
+		t.Errorf("got %v, want [device1 device2]", devices)
+	}
+}
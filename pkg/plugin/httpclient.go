@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// headerRoundTripper injects a fixed set of custom headers into every
+// outbound request, similar to how Grafana's own Prometheus datasource lets
+// users attach headers to a configured HTTP client.
+type headerRoundTripper struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (rt *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range rt.headers {
+		req.Header.Set(k, v)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// newHTTPClient builds the *http.Client used for every call to the Astarte
+// API from the datasource settings, so self-hosted installs behind a
+// TLS-terminating proxy or a corporate forward proxy can be reached. It also
+// returns the *tls.Config the transport was built with, so callers that need
+// their own TLS-aware connection (RunStream's Rooms websocket) don't have to
+// rebuild it from settings a second time.
+func newHTTPClient(settings appEngineDataSourceSourceSettings) (*http.Client, *tls.Config, error) {
+	transport, err := newTransport(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rt http.RoundTripper = transport
+	if len(settings.CustomHeaders) > 0 {
+		rt = &headerRoundTripper{headers: settings.CustomHeaders, next: transport}
+	}
+
+	timeout := defaultTimeout
+	if settings.TimeoutSeconds > 0 {
+		timeout = time.Duration(settings.TimeoutSeconds) * time.Second
+	}
+
+	httpClient := &http.Client{Transport: rt, Timeout: timeout}
+
+	if settings.AuthType == "oauth2" {
+		oauthConf := &clientcredentials.Config{
+			ClientID:     settings.OAuth2ClientID,
+			ClientSecret: settings.oauth2ClientSecret,
+			TokenURL:     settings.OAuth2TokenURL,
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+		oauthClient := oauthConf.Client(ctx)
+		// clientcredentials.Config.Client only carries Transport over into
+		// the oauth2-wrapped client (as the token source transport's Base);
+		// Timeout is not copied, which would otherwise silently drop
+		// timeoutSeconds for every OAuth2-authenticated datasource.
+		oauthClient.Timeout = timeout
+		return oauthClient, transport.TLSClientConfig, nil
+	}
+
+	return httpClient, transport.TLSClientConfig, nil
+}
+
+func newTransport(settings appEngineDataSourceSourceSettings) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.TLSSkipVerify}
+
+	if settings.tlsCACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(settings.tlsCACert)) {
+			return nil, fmt.Errorf("could not parse tlsCACert as a PEM certificate bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.tlsClientCert != "" || settings.tlsClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(settings.tlsClientCert), []byte(settings.tlsClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse TLS client certificate/key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	if settings.HTTPProxy != "" {
+		proxyURL, err := url.Parse(settings.HTTPProxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse httpProxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/client"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errClass buckets an error into a small, low-cardinality set so metrics and
+// logs stay useful for dashboards and alerting instead of exploding into one
+// series per distinct error message.
+type errClass string
+
+const (
+	errClassNone        errClass = "none"
+	errClassAuth        errClass = "auth"
+	errClassNotFound    errClass = "not_found"
+	errClassTimeout     errClass = "timeout"
+	errClassUpstream5xx errClass = "upstream_5xx"
+	errClassPlugin      errClass = "plugin"
+)
+
+func classifyError(err error) errClass {
+	if err == nil {
+		return errClassNone
+	}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.HTTPStatusCode == http.StatusUnauthorized || apiErr.HTTPStatusCode == http.StatusForbidden:
+			return errClassAuth
+		case apiErr.HTTPStatusCode == http.StatusNotFound:
+			return errClassNotFound
+		case apiErr.HTTPStatusCode >= http.StatusInternalServerError:
+			return errClassUpstream5xx
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errClassTimeout
+	}
+
+	return errClassPlugin
+}
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "astarte_datasource",
+		Name:      "requests_total",
+		Help:      "Total Astarte datasource requests, by endpoint and error class.",
+	}, []string{"endpoint", "error_class"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "astarte_datasource",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of Astarte datasource requests, by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration)
+}
+
+// tracer is shared by every handler and outbound call span in this package.
+var tracer = tracing.DefaultTracer()
+
+// instrumentRequest starts a span for a top-level handler call (QueryData,
+// CheckHealth, CallResource) and returns a finish func that records
+// Prometheus metrics and a single structured summary log line. Detail that
+// used to be logged at Info on every step now belongs at Debug.
+func instrumentRequest(ctx context.Context, endpoint string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, endpoint, trace.WithAttributes(attrs...))
+
+	return ctx, func(err error) {
+		duration := time.Since(start)
+		class := classifyError(err)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+
+		requestsTotal.WithLabelValues(endpoint, string(class)).Inc()
+		requestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+
+		log.DefaultLogger.Info("Handled Astarte datasource request",
+			"endpoint", endpoint, "duration_ms", duration.Milliseconds(), "error_class", class)
+	}
+}
+
+// startSpan opens a span for a single outbound Astarte API call (a paginator
+// page fetch, GetInterface, GetDevice, GetDevicesStats...) so traces show
+// where time is actually spent within a handler. The returned end func
+// records the call's error, if any, and closes the span; call it with the
+// error produced by the call this span wraps.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
@@ -2,19 +2,20 @@ package plugin
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
-	"time"
+	"sync"
 
 	"github.com/astarte-platform/astarte-go/client"
 	"github.com/astarte-platform/astarte-go/interfaces"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
-	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Make sure SampleDatasource implements required interfaces. This is important to do
@@ -27,18 +28,32 @@ import (
 // is useful to clean up resources used by previous datasource instance when a new datasource
 // instance created upon datasource settings changed.
 var (
-	_ backend.QueryDataHandler    = (*AppEngineDatasource)(nil)
-	_ backend.CheckHealthHandler  = (*AppEngineDatasource)(nil)
-	_ backend.CallResourceHandler = (*AppEngineDatasource)(nil)
-	// We're not interested in streaming
-	// _ backend.StreamHandler         = (*SampleDatasource)(nil)
+	_ backend.QueryDataHandler      = (*AppEngineDatasource)(nil)
+	_ backend.CheckHealthHandler    = (*AppEngineDatasource)(nil)
+	_ backend.CallResourceHandler   = (*AppEngineDatasource)(nil)
+	_ backend.StreamHandler         = (*AppEngineDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*AppEngineDatasource)(nil)
 )
 
 type appEngineDataSourceSourceSettings struct {
-	ApiUrl string `json:"apiUrl"`
-	Realm  string `json:"realm"`
-	Token  string `json:"token"`
+	ApiUrl   string `json:"apiUrl"`
+	Realm    string `json:"realm"`
+	Token    string `json:"token"`
+	AuthType string `json:"authType"` // "jwt" (default) or "oauth2"
+
+	TLSSkipVerify  bool              `json:"tlsSkipVerify"`
+	HTTPProxy      string            `json:"httpProxy"`
+	TimeoutSeconds int               `json:"timeoutSeconds"`
+	CustomHeaders  map[string]string `json:"customHeaders"`
+
+	OAuth2ClientID string `json:"oauth2ClientId"`
+	OAuth2TokenURL string `json:"oauth2TokenUrl"`
+
+	// Secure fields, decrypted from DecryptedSecureJSONData.
+	tlsCACert          string
+	tlsClientCert      string
+	tlsClientKey       string
+	oauth2ClientSecret string
 }
 
 func newAppEngineDatasourceSettings(instanceSettings backend.DataSourceInstanceSettings) (appEngineDataSourceSourceSettings, error) {
@@ -46,32 +61,50 @@ func newAppEngineDatasourceSettings(instanceSettings backend.DataSourceInstanceS
 	if err := json.Unmarshal(instanceSettings.JSONData, &settings); err != nil {
 		return appEngineDataSourceSourceSettings{}, err
 	}
+
+	secure := instanceSettings.DecryptedSecureJSONData
+	settings.tlsCACert = secure["tlsCACert"]
+	settings.tlsClientCert = secure["tlsClientCert"]
+	settings.tlsClientKey = secure["tlsClientKey"]
+	settings.oauth2ClientSecret = secure["oauth2ClientSecret"]
+
 	return settings, nil
 }
 
 // NewAppEngineDatasource creates a new datasource instance.
 func NewAppEngineDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	log.DefaultLogger.Info("NewAppEngineDatasource called with", "backend_settings", settings)
+	log.DefaultLogger.Debug("NewAppEngineDatasource called with", "backend_settings", settings)
 
-	datasource := &AppEngineDatasource{}
+	datasource := &AppEngineDatasource{streams: make(map[string]*deviceStream)}
 	dsSettings, err := newAppEngineDatasourceSettings(settings)
 	if err != nil {
 		log.DefaultLogger.Error("Cannot read settings", "error", err)
 		return nil, err
 	}
-	log.DefaultLogger.Info("Starting with settings:", "realm", dsSettings.Realm, "token", dsSettings.Token, "apiUrl", dsSettings.ApiUrl)
+	log.DefaultLogger.Debug("Starting with settings:", "realm", dsSettings.Realm, "apiUrl", dsSettings.ApiUrl)
+
+	httpClient, tlsConfig, err := newHTTPClient(dsSettings)
+	if err != nil {
+		log.DefaultLogger.Error("Cannot build HTTP client from settings", "error", err)
+		return nil, err
+	}
 
 	// If localhost is used, one must specify AppEngine individual URL
-	astarteAPIClient, err := client.NewClient(dsSettings.ApiUrl, nil)
+	astarteAPIClient, err := client.NewClient(dsSettings.ApiUrl, httpClient)
 	//astarteAPIClient, err := client.NewClientWithIndividualURLs(map[misc.AstarteService]string{misc.AppEngine: "http://localhost:4002"}, nil)
 	if err != nil {
 		log.DefaultLogger.Error("Cannot setup API client: ", "error", err)
 		return nil, err
 	}
 
-	astarteAPIClient.SetToken(dsSettings.Token)
+	if dsSettings.AuthType != "oauth2" {
+		astarteAPIClient.SetToken(dsSettings.Token)
+	}
 	datasource.astarteAPIClient = astarteAPIClient
 	datasource.realm = dsSettings.Realm
+	datasource.apiURL = dsSettings.ApiUrl
+	datasource.token = dsSettings.Token
+	datasource.tlsConfig = tlsConfig
 	return datasource, nil
 }
 
@@ -79,6 +112,18 @@ func NewAppEngineDatasource(settings backend.DataSourceInstanceSettings) (instan
 type AppEngineDatasource struct {
 	astarteAPIClient *client.Client
 	realm            string
+
+	// apiURL, token and tlsConfig mirror what astarteAPIClient was built
+	// with, so RunStream can open its own Rooms websocket connection
+	// (astarteAPIClient itself has no streaming transport to reuse).
+	apiURL    string
+	token     string
+	tlsConfig *tls.Config
+
+	// streams tracks the subscribed channels so RunStream can build each
+	// frame schema once and reuse it for every incoming message.
+	streams   map[string]*deviceStream
+	streamsMu sync.Mutex
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -95,7 +140,9 @@ func (d *AppEngineDatasource) Dispose() {
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
 // contains Frames ([]*Frame).
 func (d *AppEngineDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
-	log.DefaultLogger.Info("QueryData called", "request", req)
+	ctx, finish := instrumentRequest(ctx, "query_data", attribute.Int("query_count", len(req.Queries)))
+
+	var firstErr error
 
 	// create response struct
 	response := backend.NewQueryDataResponse()
@@ -103,13 +150,17 @@ func (d *AppEngineDatasource) QueryData(ctx context.Context, req *backend.QueryD
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
 		res := d.query(ctx, req.PluginContext, q)
+		if res.Error != nil && firstErr == nil {
+			firstErr = res.Error
+		}
 
 		// save the response in a hashmap
 		// based on with RefID as identifier
 		response.Responses[q.RefID] = res
 	}
 
-	log.DefaultLogger.Info("Returning response", "response", response)
+	log.DefaultLogger.Debug("Returning response", "response", response)
+	finish(firstErr)
 	return response, nil
 }
 
@@ -117,15 +168,31 @@ type queryModel struct {
 	Device        string `json:"device"`
 	InterfaceName string `json:"interfaceName"`
 	Path          string `json:"path"`
+
+	// Downsampler, when set to one of avg|min|max|last|count, bounds the
+	// number of samples returned on long time ranges by aggregating them
+	// into MaxDataPoints fixed-width buckets instead of returning every
+	// sample the paginator walks through.
+	Downsampler   string `json:"downsampler"`
+	MaxDataPoints int64  `json:"maxDataPoints"`
+
+	// Devices and DeviceGroup are alternatives to Device for fleet
+	// dashboards: either an explicit device list or a device group name
+	// resolved through the groups API. At most one should be set; Device
+	// remains the single-device shorthand used by existing dashboards.
+	Devices     []string `json:"devices"`
+	DeviceGroup string   `json:"deviceGroup"`
 }
 
-func (d *AppEngineDatasource) query(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+// query resolves the requested interface once and dispatches to the
+// individual or object-aggregate reading path accordingly.
+func (d *AppEngineDatasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
 	response := backend.DataResponse{}
 
 	// Unmarshal the JSON into our queryModel.
 	var qm queryModel
 
-	log.DefaultLogger.Info("Received query JSON", "json_as_string", string(query.JSON))
+	log.DefaultLogger.Debug("Received query JSON", "json_as_string", string(query.JSON))
 
 	response.Error = json.Unmarshal(query.JSON, &qm)
 	if response.Error != nil {
@@ -133,90 +200,71 @@ func (d *AppEngineDatasource) query(_ context.Context, pCtx backend.PluginContex
 		return response
 	}
 
-	// create data frame response.
-	frame := data.NewFrame("response")
-
-	paginator, err := d.astarteAPIClient.AppEngine.GetDatastreamsTimeWindowPaginator(d.realm, qm.Device, client.AstarteDeviceID, qm.InterfaceName,
-		qm.Path, query.TimeRange.From, query.TimeRange.To, client.AscendingOrder)
+	if query.QueryType == annotationQueryType {
+		return d.queryAnnotations(ctx, qm, query.TimeRange)
+	}
 
+	_, endSpan := startSpan(ctx, "astarte.get_interface", attribute.String("realm", d.realm), attribute.String("interface", qm.InterfaceName))
+	interfaceDoc, err := d.astarteAPIClient.RealmManagement.GetInterface(d.realm, qm.InterfaceName, 0)
+	endSpan(err)
 	if err != nil {
+		log.DefaultLogger.Error("Cannot resolve interface for query", "error", err, "interface", qm.InterfaceName)
 		response.Error = err
 		return response
 	}
 
-	timestamps := []time.Time{}
-	values := []float64{}
-
-	for ok := true; ok; ok = paginator.HasNextPage() {
-		page, err := paginator.GetNextPage()
-		if err != nil {
-			log.DefaultLogger.Error("Next page paginator error", "error", err)
-			response.Error = err
-			return response
-		}
-
-		log.DefaultLogger.Info("Start reading Astarte data")
-
-		for _, v := range page {
-			switch v.Value.(type) {
-			case float64:
-				timestamps = append(timestamps, v.Timestamp)
-				values = append(values, v.Value.(float64))
-			case int64:
-				timestamps = append(timestamps, v.Timestamp)
-				values = append(values, float64(v.Value.(int64)))
-			case string:
-				if f, err := strconv.ParseFloat(v.Value.(string), 64); err != nil {
-					log.DefaultLogger.Warn("Could not parse as numeric datatype", "value", v.Value, "error", err)
-				} else {
-					timestamps = append(timestamps, v.Timestamp)
-					values = append(values, f)
-				}
-			default:
-				response.Error = fmt.Errorf("Device %s has data of non-numeric type on interface %s, path %s", qm.Device, qm.InterfaceName, qm.Path)
-				log.DefaultLogger.Error("Error on value type read", "error", response.Error)
-				return response
-			}
-		}
+	devices, err := d.resolveDevices(ctx, qm)
+	if err != nil {
+		log.DefaultLogger.Error("Cannot resolve devices for query", "error", err)
+		response.Error = err
+		return response
 	}
 
-	log.DefaultLogger.Info("Successful Astarte data reading")
+	if len(devices) == 0 {
+		response.Error = fmt.Errorf("query resolved to no devices (empty or misspelled device group %q?)", qm.DeviceGroup)
+		return response
+	}
 
-	TimeField := data.NewField("Time", nil, timestamps)
-	log.DefaultLogger.Info("Successful time field creation")
+	if len(devices) > 1 {
+		return d.queryFanOut(ctx, devices, interfaceDoc, qm, query)
+	}
 
-	ValueField := data.NewField("Value", nil, values)
-	log.DefaultLogger.Info("Successful value field creation")
+	paginator, err := d.astarteAPIClient.AppEngine.GetDatastreamsTimeWindowPaginator(d.realm, devices[0], client.AstarteDeviceID, qm.InterfaceName,
+		qm.Path, query.TimeRange.From, query.TimeRange.To, client.AscendingOrder)
 
-	frame.Fields = append(frame.Fields, TimeField, ValueField)
-	log.DefaultLogger.Info("Successful frame field append")
+	if err != nil {
+		response.Error = err
+		return response
+	}
 
-	// add the frames to the response.
-	response.Frames = append(response.Frames, frame)
-	log.DefaultLogger.Info("Successful response frame append", "response", response)
+	if interfaceDoc.Aggregation == interfaces.ObjectAggregation {
+		return queryObjectAggregate(ctx, paginator, interfaceDoc, qm, query)
+	}
 
-	return response
+	return queryIndividual(ctx, paginator, interfaceDoc, qm, query)
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
-func (d *AppEngineDatasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	log.DefaultLogger.Info("CheckHealth called", "request", req)
+func (d *AppEngineDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	ctx, finish := instrumentRequest(ctx, "check_health", attribute.String("realm", d.realm))
 
 	var status = backend.HealthStatusOk
 	var message = "Data source is working"
 
 	// Run an actual query to Astarte, so that our JWT is checked, too
+	_, endSpan := startSpan(ctx, "astarte.get_devices_stats", attribute.String("realm", d.realm))
 	_, err := d.astarteAPIClient.AppEngine.GetDevicesStats(d.realm)
+	endSpan(err)
 
 	if err != nil {
-		log.DefaultLogger.Error("CheckHealth error", "err", err)
 		status = backend.HealthStatusError
 		message = err.Error()
 	}
 
+	finish(err)
 	return &backend.CheckHealthResult{
 		Status:  status,
 		Message: message,
@@ -224,41 +272,95 @@ func (d *AppEngineDatasource) CheckHealth(_ context.Context, req *backend.CheckH
 }
 
 func (d *AppEngineDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
-	log.DefaultLogger.Info("CallResource  called", "request", req)
+	ctx, finish := instrumentRequest(ctx, "call_resource", attribute.String("path", req.URL))
 
 	u, _ := url.Parse(req.URL)
 	params, _ := url.ParseQuery(u.RawQuery)
 
+	var err error
 	if params["device_id"] != nil {
 		// if device_id is provided, we've been asked for device introspection
-		interfaces, err := d.getDeviceIntrospection(params["device_id"][0])
+		var interfaces []introspectionEntry
+		interfaces, err = d.getDeviceIntrospection(ctx, params["device_id"][0])
 		if err != nil {
-			sendBadRequest(err, sender)
+			finish(err)
+			return sendBadRequest(err, sender)
 		}
 		body, _ := json.Marshal(interfaces)
+		finish(nil)
 		return sendResult(body, sender)
 	} else if params["name"] != nil && params["major"] != nil {
 		// we assume a valid int is always passed as interface major value
 		major, _ := strconv.Atoi(params["major"][0])
-		iface, err := d.getInterface(params["name"][0], major)
+		var iface interfaces.AstarteInterface
+		iface, err = d.getInterface(ctx, params["name"][0], major)
 		if err != nil {
-			sendBadRequest(err, sender)
+			finish(err)
+			return sendBadRequest(err, sender)
 		}
 		body, _ := json.Marshal(iface)
+		finish(nil)
+		return sendResult(body, sender)
+	} else if params["groups"] != nil {
+		// the frontend wants the realm's device groups, to populate a template variable
+		var groups []string
+		groups, err = d.getGroups(ctx)
+		if err != nil {
+			finish(err)
+			return sendBadRequest(err, sender)
+		}
+		body, _ := json.Marshal(groups)
+		finish(nil)
+		return sendResult(body, sender)
+	} else if params["group"] != nil {
+		// the frontend wants the member device IDs of a specific group
+		var members []string
+		members, err = d.getGroupDevices(ctx, params["group"][0])
+		if err != nil {
+			finish(err)
+			return sendBadRequest(err, sender)
+		}
+		body, _ := json.Marshal(members)
+		finish(nil)
 		return sendResult(body, sender)
-	} else {
-		// don't know what else could we provide
-		return sendBadRequest(fmt.Errorf("unexpected request"), sender)
 	}
+
+	err = fmt.Errorf("unexpected request")
+	finish(err)
+	return sendBadRequest(err, sender)
+}
+
+func (d *AppEngineDatasource) getGroups(ctx context.Context) ([]string, error) {
+	_, endSpan := startSpan(ctx, "astarte.list_groups", attribute.String("realm", d.realm))
+	groups, err := d.astarteAPIClient.AppEngine.ListGroups(d.realm)
+	endSpan(err)
+	if err != nil {
+		log.DefaultLogger.Error("Can't list device groups", "err", err)
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (d *AppEngineDatasource) getGroupDevices(ctx context.Context, group string) ([]string, error) {
+	_, endSpan := startSpan(ctx, "astarte.get_group_devices", attribute.String("realm", d.realm), attribute.String("group", group))
+	members, err := d.astarteAPIClient.AppEngine.ListGroupDevices(d.realm, group)
+	endSpan(err)
+	if err != nil {
+		log.DefaultLogger.Error("Can't list group devices", "err", err, "group", group)
+		return nil, err
+	}
+	return members, nil
 }
 
-func (d *AppEngineDatasource) getInterface(interfaceName string, interfaceMajor int) (interfaces.AstarteInterface, error) {
+func (d *AppEngineDatasource) getInterface(ctx context.Context, interfaceName string, interfaceMajor int) (interfaces.AstarteInterface, error) {
+	_, endSpan := startSpan(ctx, "astarte.get_interface", attribute.String("realm", d.realm), attribute.String("interface", interfaceName))
 	interfaceDoc, err := d.astarteAPIClient.RealmManagement.GetInterface(d.realm, interfaceName, interfaceMajor)
+	endSpan(err)
 	if err != nil {
 		log.DefaultLogger.Error("Can't get interface data", "err", err, "interface", interfaceName, "interfaceMajor", interfaceMajor)
 		return interfaces.AstarteInterface{}, err
 	}
-	log.DefaultLogger.Info("Received doc for interface", "interface", interfaceName, "major", interfaceMajor)
+	log.DefaultLogger.Debug("Received doc for interface", "interface", interfaceName, "major", interfaceMajor)
 
 	return interfaceDoc, nil
 }
@@ -269,13 +371,15 @@ type introspectionEntry struct {
 	Minor int    `json:"minor"`
 }
 
-func (d *AppEngineDatasource) getDeviceIntrospection(deviceID string) ([]introspectionEntry, error) {
+func (d *AppEngineDatasource) getDeviceIntrospection(ctx context.Context, deviceID string) ([]introspectionEntry, error) {
+	_, endSpan := startSpan(ctx, "astarte.get_device", attribute.String("realm", d.realm), attribute.String("device_id", deviceID))
 	details, err := d.astarteAPIClient.AppEngine.GetDevice(d.realm, deviceID, client.AstarteDeviceID)
+	endSpan(err)
 	if err != nil {
 		log.DefaultLogger.Error("Can't get device introspection", "err", err, "device_id", deviceID)
 		return nil, err
 	}
-	log.DefaultLogger.Info("Received Astarte introspection for device", "device_id", deviceID)
+	log.DefaultLogger.Debug("Received Astarte introspection for device", "device_id", deviceID)
 	interfaces := []introspectionEntry{}
 	for interfaceName, interfaceDetails := range details.Introspection {
 		interfaces = append(interfaces, introspectionEntry{Name: interfaceName, Major: interfaceDetails.Major, Minor: interfaceDetails.Minor})
@@ -285,7 +389,7 @@ func (d *AppEngineDatasource) getDeviceIntrospection(deviceID string) ([]introsp
 }
 
 func sendResult(body []byte, sender backend.CallResourceResponseSender) error {
-	log.DefaultLogger.Info("Sending call resource response")
+	log.DefaultLogger.Debug("Sending call resource response")
 	return sender.Send(&backend.CallResourceResponse{
 		Status: http.StatusOK,
 		Body:   body,
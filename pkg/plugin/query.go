@@ -0,0 +1,392 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/client"
+	"github.com/astarte-platform/astarte-go/interfaces"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// queryIndividual walks the paginator for a plain datastream interface and
+// builds one typed Value field next to Time, honouring whatever Go type the
+// mapping resolves to instead of forcing everything into float64.
+func queryIndividual(ctx context.Context, paginator *client.DatastreamsPaginator, interfaceDoc interfaces.AstarteInterface, qm queryModel, query backend.DataQuery) backend.DataResponse {
+	response := backend.DataResponse{}
+	frame := data.NewFrame("response")
+
+	mapping, err := findMapping(interfaceDoc, qm.Path)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	if ds := newDownsamplerIfRequested(qm, query, mapping.Type); ds != nil {
+		return downsampleIndividual(ctx, paginator, mapping, qm, ds)
+	}
+
+	timestamps := []time.Time{}
+	builder := newFieldBuilder(mapping.Type)
+
+	for ok := true; ok; ok = paginator.HasNextPage() {
+		_, endSpan := startSpan(ctx, "astarte.paginator_page", attribute.String("interface", qm.InterfaceName), attribute.String("path", qm.Path))
+		page, err := paginator.GetNextPage()
+		endSpan(err)
+		if err != nil {
+			log.DefaultLogger.Error("Next page paginator error", "error", err)
+			response.Error = err
+			return response
+		}
+
+		for _, v := range page {
+			converted, err := convertValue(v.Value, mapping.Type)
+			if err != nil {
+				log.DefaultLogger.Warn("Could not convert value, skipping sample", "value", v.Value, "error", err)
+				continue
+			}
+			timestamps = append(timestamps, v.Timestamp)
+			builder.append(converted)
+		}
+	}
+
+	valueField, err := builder.field("Value")
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	frame.Fields = append(frame.Fields, data.NewField("Time", nil, timestamps), valueField)
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// downsampleIndividual is the aggregated counterpart of queryIndividual's
+// main loop: it never materializes the raw samples, only maxDataPoints
+// buckets, so memory stays bounded on multi-day, high-frequency ranges.
+func downsampleIndividual(ctx context.Context, paginator *client.DatastreamsPaginator, mapping interfaces.AstarteInterfaceMapping, qm queryModel, ds *downsampler) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	for ok := true; ok; ok = paginator.HasNextPage() {
+		_, endSpan := startSpan(ctx, "astarte.paginator_page", attribute.String("interface", qm.InterfaceName), attribute.String("path", qm.Path))
+		page, err := paginator.GetNextPage()
+		endSpan(err)
+		if err != nil {
+			log.DefaultLogger.Error("Next page paginator error", "error", err)
+			response.Error = err
+			return response
+		}
+
+		for _, v := range page {
+			converted, err := convertValue(v.Value, mapping.Type)
+			if err != nil {
+				log.DefaultLogger.Warn("Could not convert value, skipping sample", "value", v.Value, "error", err)
+				continue
+			}
+			f, ok := toFloat64(converted)
+			if !ok {
+				log.DefaultLogger.Warn("Could not coerce numeric value for downsampling, skipping sample", "value", converted)
+				continue
+			}
+			ds.add(v.Timestamp, f)
+		}
+	}
+
+	times, values := ds.results()
+	frame := data.NewFrame("response",
+		data.NewField("Time", nil, times),
+		data.NewField("Value", nil, values),
+	)
+	frame.SetMeta(&data.FrameMeta{Custom: map[string]interface{}{"intervalMs": ds.bucketWidth().Milliseconds()}})
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// newDownsamplerIfRequested returns nil when the query didn't ask for
+// downsampling, or the mapping type isn't numeric and therefore can't be
+// reduced with avg/min/max/last/count.
+func newDownsamplerIfRequested(qm queryModel, query backend.DataQuery, mappingType interfaces.AstarteMappingType) *downsampler {
+	if qm.Downsampler == "" {
+		return nil
+	}
+	if mappingType != interfaces.TypeDouble && mappingType != interfaces.TypeInteger && mappingType != interfaces.TypeLongInteger {
+		return nil
+	}
+
+	maxDataPoints := qm.MaxDataPoints
+	if maxDataPoints <= 0 {
+		maxDataPoints = query.MaxDataPoints
+	}
+
+	return newDownsampler(qm.Downsampler, query.TimeRange.From, query.TimeRange.To, maxDataPoints)
+}
+
+// toFloat64 coerces the numeric Go types convertValue can hand back into a
+// float64 so the downsampler can aggregate int64 and float64 mappings alike.
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// queryObjectAggregate walks the paginator for an object-aggregate interface,
+// where every sample carries a map of member name to value, and unpacks it
+// into one data.Field per member.
+func queryObjectAggregate(ctx context.Context, paginator *client.DatastreamsPaginator, interfaceDoc interfaces.AstarteInterface, qm queryModel, _ backend.DataQuery) backend.DataResponse {
+	response := backend.DataResponse{}
+	frame := data.NewFrame("response")
+
+	members, err := aggregateMembers(interfaceDoc, qm.Path)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	if qm.Downsampler != "" {
+		// Bucketing an object-aggregate sample would mean reducing each
+		// member independently, which can each end up with a different set
+		// of non-empty buckets (a member missing from some samples) and no
+		// longer share a single Time field across the frame. Rather than
+		// guess at a reconciliation strategy, refuse the combination so a
+		// long, high-rate aggregate query fails fast instead of silently
+		// buffering every sample for the whole range.
+		response.Error = fmt.Errorf("downsampling is not supported for object-aggregate interface %q; remove the downsampler or query an individual-datastream interface", interfaceDoc.Name)
+		return response
+	}
+
+	timestamps := []time.Time{}
+	builders := map[string]*fieldBuilder{}
+	for name, mapping := range members {
+		builders[name] = newFieldBuilder(mapping.Type)
+	}
+
+	for ok := true; ok; ok = paginator.HasNextPage() {
+		_, endSpan := startSpan(ctx, "astarte.paginator_page", attribute.String("interface", qm.InterfaceName), attribute.String("path", qm.Path))
+		page, err := paginator.GetNextPage()
+		endSpan(err)
+		if err != nil {
+			log.DefaultLogger.Error("Next page paginator error", "error", err)
+			response.Error = err
+			return response
+		}
+
+		for _, v := range page {
+			sample, ok := v.Value.(map[string]interface{})
+			if !ok {
+				log.DefaultLogger.Warn("Object-aggregate sample was not a map, skipping", "value", v.Value)
+				continue
+			}
+
+			timestamps = append(timestamps, v.Timestamp)
+			for name, builder := range builders {
+				converted, err := convertValue(sample[name], members[name].Type)
+				if err != nil {
+					log.DefaultLogger.Warn("Could not convert aggregate member, using zero value", "member", name, "error", err)
+					builder.appendZero()
+					continue
+				}
+				builder.append(converted)
+			}
+		}
+	}
+
+	frame.Fields = append(frame.Fields, data.NewField("Time", nil, timestamps))
+	for name, builder := range builders {
+		field, err := builder.field(name)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// findMapping returns the single mapping of an individual interface matching
+// the requested path.
+func findMapping(interfaceDoc interfaces.AstarteInterface, path string) (interfaces.AstarteInterfaceMapping, error) {
+	for _, m := range interfaceDoc.Mappings {
+		if endpointMatches(m.Endpoint, path) {
+			return m, nil
+		}
+	}
+	return interfaces.AstarteInterfaceMapping{}, fmt.Errorf("no mapping found for path %q on interface %q", path, interfaceDoc.Name)
+}
+
+// aggregateMembers returns, keyed by the trailing path segment, every mapping
+// of an object-aggregate interface whose common endpoint prefix matches path.
+func aggregateMembers(interfaceDoc interfaces.AstarteInterface, path string) (map[string]interfaces.AstarteInterfaceMapping, error) {
+	members := map[string]interfaces.AstarteInterfaceMapping{}
+	for _, m := range interfaceDoc.Mappings {
+		prefix, leaf := splitEndpoint(m.Endpoint)
+		if endpointMatches(prefix, path) {
+			members[leaf] = m
+		}
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no aggregate members found for path %q on interface %q", path, interfaceDoc.Name)
+	}
+	return members, nil
+}
+
+// splitEndpoint splits an object-aggregate mapping endpoint into its common
+// prefix (the group path) and its trailing member name.
+func splitEndpoint(endpoint string) (prefix, leaf string) {
+	idx := strings.LastIndex(endpoint, "/")
+	if idx < 0 {
+		return endpoint, endpoint
+	}
+	return endpoint[:idx], endpoint[idx+1:]
+}
+
+// endpointMatches compares an Astarte parametric endpoint (e.g. /%{sensorId}/value)
+// against a concrete path, treating %{...} segments as wildcards.
+func endpointMatches(endpoint, path string) bool {
+	endpointParts := strings.Split(strings.Trim(endpoint, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(endpointParts) != len(pathParts) {
+		return false
+	}
+	for i, part := range endpointParts {
+		if strings.HasPrefix(part, "%{") {
+			continue
+		}
+		if part != pathParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldBuilder accumulates typed samples for a single mapping so the
+// data.Field can be created once all pages have been read.
+type fieldBuilder struct {
+	mappingType interfaces.AstarteMappingType
+	bools       []bool
+	strings     []string
+	ints        []int64
+	floats      []float64
+	times       []time.Time
+	blobs       [][]byte
+}
+
+func newFieldBuilder(mappingType interfaces.AstarteMappingType) *fieldBuilder {
+	return &fieldBuilder{mappingType: mappingType}
+}
+
+func (b *fieldBuilder) append(value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		b.bools = append(b.bools, v)
+	case string:
+		b.strings = append(b.strings, v)
+	case int64:
+		b.ints = append(b.ints, v)
+	case float64:
+		b.floats = append(b.floats, v)
+	case time.Time:
+		b.times = append(b.times, v)
+	case []byte:
+		b.blobs = append(b.blobs, v)
+	}
+}
+
+func (b *fieldBuilder) appendZero() {
+	switch b.mappingType {
+	case interfaces.TypeBoolean:
+		b.append(false)
+	case interfaces.TypeString:
+		b.append("")
+	case interfaces.TypeInteger, interfaces.TypeLongInteger:
+		b.append(int64(0))
+	case interfaces.TypeDouble:
+		b.append(float64(0))
+	case interfaces.TypeDateTime:
+		b.append(time.Time{})
+	case interfaces.TypeBinaryBlob:
+		b.append([]byte(nil))
+	}
+}
+
+func (b *fieldBuilder) field(name string) (*data.Field, error) {
+	switch b.mappingType {
+	case interfaces.TypeBoolean:
+		return data.NewField(name, nil, b.bools), nil
+	case interfaces.TypeString:
+		return data.NewField(name, nil, b.strings), nil
+	case interfaces.TypeInteger, interfaces.TypeLongInteger:
+		return data.NewField(name, nil, b.ints), nil
+	case interfaces.TypeDouble:
+		return data.NewField(name, nil, b.floats), nil
+	case interfaces.TypeDateTime:
+		return data.NewField(name, nil, b.times), nil
+	case interfaces.TypeBinaryBlob:
+		return data.NewField(name, nil, b.blobs), nil
+	default:
+		return nil, fmt.Errorf("unsupported mapping type %v", b.mappingType)
+	}
+}
+
+// convertValue coerces a raw value decoded off the wire into the Go type the
+// field builder for mappingType expects. Integer and double mappings accept
+// int64, float64 or a numeric string; boolean and string mappings accept only
+// their own type; datetime accepts only a time.Time and binaryblob only a
+// []byte. Any other input is an error.
+func convertValue(raw interface{}, mappingType interfaces.AstarteMappingType) (interface{}, error) {
+	switch mappingType {
+	case interfaces.TypeBoolean:
+		if v, ok := raw.(bool); ok {
+			return v, nil
+		}
+	case interfaces.TypeString:
+		if v, ok := raw.(string); ok {
+			return v, nil
+		}
+	case interfaces.TypeInteger, interfaces.TypeLongInteger:
+		switch v := raw.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return int64(v), nil
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	case interfaces.TypeDouble:
+		switch v := raw.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+	case interfaces.TypeDateTime:
+		if v, ok := raw.(time.Time); ok {
+			return v, nil
+		}
+	case interfaces.TypeBinaryBlob:
+		if v, ok := raw.([]byte); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert value %v (%T) to mapping type %v", raw, raw, mappingType)
+}
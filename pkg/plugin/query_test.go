@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+func TestEndpointMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		path     string
+		want     bool
+	}{
+		{"exact match", "/value", "/value", true},
+		{"exact mismatch", "/value", "/other", false},
+		{"single wildcard segment", "/%{sensorId}/value", "/sensor0/value", true},
+		{"wildcard segment with mismatched literal", "/%{sensorId}/value", "/sensor0/other", false},
+		{"different segment count", "/%{sensorId}/value", "/value", false},
+		{"leading/trailing slashes are ignored", "/%{sensorId}/value/", "sensor0/value", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := endpointMatches(tt.endpoint, tt.path); got != tt.want {
+				t.Errorf("endpointMatches(%q, %q) = %v, want %v", tt.endpoint, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		endpoint   string
+		wantPrefix string
+		wantLeaf   string
+	}{
+		{"nested member", "/%{sensorId}/temperature", "/%{sensorId}", "temperature"},
+		{"no slash", "value", "value", "value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, leaf := splitEndpoint(tt.endpoint)
+			if prefix != tt.wantPrefix || leaf != tt.wantLeaf {
+				t.Errorf("splitEndpoint(%q) = (%q, %q), want (%q, %q)", tt.endpoint, prefix, leaf, tt.wantPrefix, tt.wantLeaf)
+			}
+		})
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         interface{}
+		mappingType interfaces.AstarteMappingType
+		want        interface{}
+		wantErr     bool
+	}{
+		{"bool", true, interfaces.TypeBoolean, true, false},
+		{"string", "hello", interfaces.TypeString, "hello", false},
+		{"integer from float64", float64(42), interfaces.TypeInteger, int64(42), false},
+		{"long integer from string", "9000000000", interfaces.TypeLongInteger, int64(9000000000), false},
+		{"double from int64", int64(3), interfaces.TypeDouble, float64(3), false},
+		{"double from string", "3.5", interfaces.TypeDouble, float64(3.5), false},
+		{"type mismatch errors", "not a bool", interfaces.TypeBoolean, nil, true},
+		{"unparseable integer errors", "not a number", interfaces.TypeInteger, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertValue(tt.raw, tt.mappingType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got value %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("convertValue(%v, %v) = %v, want %v", tt.raw, tt.mappingType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertValueDateTime(t *testing.T) {
+	now := time.Now()
+	got, err := convertValue(now, interfaces.TypeDateTime)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.(time.Time).Equal(now) {
+		t.Errorf("got %v, want %v", got, now)
+	}
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// roomsEvent is one "incoming_data" push forwarded by an Astarte Rooms
+// channel: a device published new data on the interface/path our watch
+// trigger was installed for.
+type roomsEvent struct {
+	DeviceID  string
+	Interface string
+	Path      string
+	Value     interface{}
+	Timestamp time.Time
+}
+
+// roomsConn is a minimal Phoenix channel client for Astarte's Rooms API
+// (wss://<apiUrl>/v1/<realm>/rooms/websocket) - just enough to join a room,
+// install a single data trigger via "watch", and receive "new_event" pushes.
+// This is the same mechanism astarte-dashboard uses to watch live device
+// data; there is no simpler client-side "subscribe" call in astarte-go.
+type roomsConn struct {
+	conn    *websocket.Conn
+	room    string
+	nextRef int
+}
+
+// dialRoom opens the websocket and sends the phx_join request for room. It
+// returns as soon as the request is written, without waiting for the join
+// reply; that reply is later skipped by next like any other phx_reply.
+func dialRoom(ctx context.Context, apiURL, token string, tlsConfig *tls.Config, realm, room string) (*roomsConn, error) {
+	wsURL := strings.Replace(strings.Replace(apiURL, "https://", "wss://", 1), "http://", "ws://", 1)
+	wsURL = strings.TrimSuffix(wsURL, "/") + "/v1/" + realm + "/rooms/websocket"
+
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+
+	conn, _, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to Astarte rooms websocket: %w", err)
+	}
+
+	rc := &roomsConn{conn: conn, room: room}
+	if err := rc.send("phx_join", map[string]interface{}{}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot join room %q: %w", room, err)
+	}
+
+	return rc, nil
+}
+
+// send writes a Phoenix channel frame: the protocol's wire format is the
+// 5-element array [join_ref, ref, topic, event, payload].
+func (rc *roomsConn) send(event string, payload interface{}) error {
+	rc.nextRef++
+	ref := fmt.Sprintf("%d", rc.nextRef)
+	frame := []interface{}{ref, ref, "rooms:" + rc.room, event, payload}
+	return rc.conn.WriteJSON(frame)
+}
+
+// watch installs a data trigger on interfaceName/path for the room, scoped
+// to device when it is non-empty, or to every device in the realm otherwise.
+func (rc *roomsConn) watch(triggerName, device, interfaceName string, interfaceMajor int, path string) error {
+	payload := map[string]interface{}{
+		"name": triggerName,
+		"simple_trigger": map[string]interface{}{
+			"type":                 "data_trigger",
+			"interface_name":       interfaceName,
+			"interface_major":      interfaceMajor,
+			"on":                   "incoming_data",
+			"value_match_operator": "*",
+			"match_path":           path,
+		},
+	}
+	if device != "" {
+		payload["device_id"] = device
+	}
+
+	return rc.send("watch", payload)
+}
+
+// next blocks until the next "new_event" push carrying incoming_data
+// arrives, skipping Phoenix protocol replies (phx_reply, heartbeats) and any
+// other trigger event types.
+func (rc *roomsConn) next() (roomsEvent, error) {
+	for {
+		var frame []json.RawMessage
+		if err := rc.conn.ReadJSON(&frame); err != nil {
+			return roomsEvent{}, err
+		}
+		if len(frame) != 5 {
+			continue
+		}
+
+		var event string
+		if err := json.Unmarshal(frame[3], &event); err != nil || event != "new_event" {
+			continue
+		}
+
+		var payload struct {
+			DeviceID string `json:"device_id"`
+			Event    struct {
+				Type      string      `json:"type"`
+				Interface string      `json:"interface"`
+				Path      string      `json:"path"`
+				Value     interface{} `json:"value"`
+			} `json:"event"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := json.Unmarshal(frame[4], &payload); err != nil || payload.Event.Type != "incoming_data" {
+			continue
+		}
+
+		return roomsEvent{
+			DeviceID:  payload.DeviceID,
+			Interface: payload.Event.Interface,
+			Path:      payload.Event.Path,
+			Value:     payload.Event.Value,
+			Timestamp: payload.Timestamp,
+		}, nil
+	}
+}
+
+func (rc *roomsConn) Close() error {
+	return rc.conn.Close()
+}
@@ -0,0 +1,243 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// streamMember is one object-aggregate field, resolved once at subscribe
+// time and kept in a fixed order so frameFromEvent's hot path never has to
+// range over a map (whose iteration order is randomized) to decide field
+// order or guess a value's type from the wire.
+type streamMember struct {
+	name        string
+	mappingType interfaces.AstarteMappingType
+}
+
+// deviceStream holds the state RunStream needs to turn AppEngine trigger
+// events into data.Frames without re-deriving the schema on every message:
+// valueType/members cache the mapping type(s) resolved from the interface at
+// subscribe time, so frameFromEvent only ever does a known, typed
+// conversion instead of inspecting each event's value.
+type deviceStream struct {
+	realm         string
+	device        string
+	interfaceName string
+	path          string
+	aggregate     bool
+	valueType     interfaces.AstarteMappingType
+	members       []streamMember
+}
+
+// parseStreamChannel splits the path a panel subscribes to: realm/device/interface/path.
+// Grafana scopes streams per datasource instance, so the realm segment is
+// kept and cross-checked against the datasource's own realm by SubscribeStream.
+func parseStreamChannel(path string) (deviceStream, error) {
+	parts := strings.SplitN(path, "/", 4)
+	if len(parts) != 4 {
+		return deviceStream{}, fmt.Errorf("invalid stream path %q, expected realm/device/interface/path", path)
+	}
+
+	return deviceStream{
+		realm:         parts[0],
+		device:        parts[1],
+		interfaceName: parts[2],
+		path:          "/" + parts[3],
+	}, nil
+}
+
+// SubscribeStream is called when a user tries to subscribe to a plugin/datasource
+// managed channel path - here we resolve the target interface once so RunStream's
+// hot loop never has to call RealmManagement again.
+func (d *AppEngineDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	log.DefaultLogger.Debug("SubscribeStream called", "path", req.Path)
+
+	channel, err := parseStreamChannel(req.Path)
+	if err != nil {
+		log.DefaultLogger.Error("Cannot parse stream path", "error", err)
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	if channel.realm != d.realm {
+		log.DefaultLogger.Error("Stream path realm does not match datasource realm", "path_realm", channel.realm, "realm", d.realm)
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+
+	_, endSpan := startSpan(ctx, "astarte.get_interface", attribute.String("realm", d.realm), attribute.String("interface", channel.interfaceName))
+	interfaceDoc, err := d.astarteAPIClient.RealmManagement.GetInterface(d.realm, channel.interfaceName, 0)
+	endSpan(err)
+	if err != nil {
+		log.DefaultLogger.Error("Cannot resolve interface for stream", "error", err, "interface", channel.interfaceName)
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	channel.aggregate = interfaceDoc.Aggregation == interfaces.ObjectAggregation
+
+	if channel.aggregate {
+		members, err := aggregateMembers(interfaceDoc, channel.path)
+		if err != nil {
+			log.DefaultLogger.Error("Cannot resolve aggregate members for stream", "error", err, "interface", channel.interfaceName, "path", channel.path)
+			return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+		}
+		channel.members = make([]streamMember, 0, len(members))
+		for name, mapping := range members {
+			channel.members = append(channel.members, streamMember{name: name, mappingType: mapping.Type})
+		}
+		sort.Slice(channel.members, func(i, j int) bool { return channel.members[i].name < channel.members[j].name })
+	} else {
+		mapping, err := findMapping(interfaceDoc, channel.path)
+		if err != nil {
+			log.DefaultLogger.Error("Cannot resolve mapping for stream", "error", err, "interface", channel.interfaceName, "path", channel.path)
+			return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+		}
+		channel.valueType = mapping.Type
+	}
+
+	d.streamsMu.Lock()
+	d.streams[req.Path] = &channel
+	d.streamsMu.Unlock()
+
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream is called when a client calls the publish method on a stream
+// path - this datasource is read-only, so every publish is rejected.
+func (d *AppEngineDatasource) PublishStream(_ context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	log.DefaultLogger.Info("PublishStream called", "path", req.Path)
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// streamRoomName derives a Rooms API room name for a subscribed channel. It
+// doesn't need to be globally unique, only unique enough that two distinct
+// panels streaming the same interface/path don't have their watch triggers
+// collide within this datasource instance's connection.
+func streamRoomName(channel *deviceStream) string {
+	device := channel.device
+	if device == "" {
+		device = "realm"
+	}
+	return fmt.Sprintf("grafana-%s-%s", device, strings.Trim(channel.interfaceName+channel.path, "/"))
+}
+
+// RunStream keeps running while a subscriber is connected, pushing frames
+// through sender. It joins an Astarte Rooms channel, installs a data trigger
+// on the requested interface/path, and translates every "new_event" push
+// into a data.Frame matching the schema decided at subscribe time.
+func (d *AppEngineDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	log.DefaultLogger.Info("RunStream called", "path", req.Path)
+
+	d.streamsMu.Lock()
+	channel, ok := d.streams[req.Path]
+	d.streamsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no subscription state for stream path %q", req.Path)
+	}
+	defer func() {
+		d.streamsMu.Lock()
+		delete(d.streams, req.Path)
+		d.streamsMu.Unlock()
+	}()
+
+	room := streamRoomName(channel)
+	rc, err := dialRoom(ctx, d.apiURL, d.token, d.tlsConfig, d.realm, room)
+	if err != nil {
+		log.DefaultLogger.Error("Cannot open rooms connection", "error", err, "device", channel.device)
+		return err
+	}
+	defer rc.Close()
+
+	if err := rc.watch(room, channel.device, channel.interfaceName, 0, channel.path); err != nil {
+		log.DefaultLogger.Error("Cannot install watch trigger", "error", err, "device", channel.device)
+		return err
+	}
+
+	events := make(chan roomsEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			event, err := rc.next()
+			if err != nil {
+				errs <- err
+				return
+			}
+			events <- event
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			log.DefaultLogger.Error("Rooms connection closed", "error", err, "device", channel.device)
+			return err
+		case event := <-events:
+			frame, err := frameFromEvent(channel, event)
+			if err != nil {
+				log.DefaultLogger.Warn("Skipping unconvertible stream event", "error", err)
+				continue
+			}
+
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				log.DefaultLogger.Error("Error sending stream frame", "error", err)
+				return err
+			}
+		}
+	}
+}
+
+// frameFromEvent turns one Rooms push into a single-row frame. The field
+// shape - names, types, and (for aggregates) order - was already decided by
+// SubscribeStream, so this never inspects event.Value's Go type or ranges
+// over a map to figure out what to build: it just converts each value to the
+// mapping type resolved at subscribe time.
+func frameFromEvent(channel *deviceStream, event roomsEvent) (*data.Frame, error) {
+	frame := data.NewFrame(channel.path,
+		data.NewField("Time", nil, []time.Time{event.Timestamp}),
+	)
+
+	if !channel.aggregate {
+		field, err := typedScalarField("Value", event.Value, channel.valueType)
+		if err != nil {
+			return nil, err
+		}
+		frame.Fields = append(frame.Fields, field)
+		return frame, nil
+	}
+
+	sample, ok := event.Value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("object-aggregate event did not carry a map payload")
+	}
+	for _, member := range channel.members {
+		field, err := typedScalarField(member.name, sample[member.name], member.mappingType)
+		if err != nil {
+			return nil, err
+		}
+		frame.Fields = append(frame.Fields, field)
+	}
+
+	return frame, nil
+}
+
+// typedScalarField converts a single raw value to mappingType and wraps it
+// in a one-row data.Field, reusing the same conversion fieldBuilder uses for
+// query results so a stream and a query agree on how a mapping type is
+// represented.
+func typedScalarField(name string, raw interface{}, mappingType interfaces.AstarteMappingType) (*data.Field, error) {
+	converted, err := convertValue(raw, mappingType)
+	if err != nil {
+		return nil, err
+	}
+	builder := newFieldBuilder(mappingType)
+	builder.append(converted)
+	return builder.field(name)
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/astarte-platform/astarte-go/interfaces"
+)
+
+func TestParseStreamChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    deviceStream
+		wantErr bool
+	}{
+		{
+			name: "single-segment value path",
+			path: "myrealm/device0/org.astarte.Test/value",
+			want: deviceStream{realm: "myrealm", device: "device0", interfaceName: "org.astarte.Test", path: "/value"},
+		},
+		{
+			name: "nested value path",
+			path: "myrealm/device0/org.astarte.Test/sensor0/value",
+			want: deviceStream{realm: "myrealm", device: "device0", interfaceName: "org.astarte.Test", path: "/sensor0/value"},
+		},
+		{
+			name:    "too few segments",
+			path:    "myrealm/device0/org.astarte.Test",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStreamChannel(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStreamChannel(%q) = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamRoomName(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel *deviceStream
+		want    string
+	}{
+		{
+			name:    "device-scoped channel",
+			channel: &deviceStream{device: "device0", interfaceName: "org.astarte.Test", path: "/value"},
+			want:    "grafana-device0-org.astarte.Test/value",
+		},
+		{
+			name:    "realm-wide channel falls back to the realm placeholder",
+			channel: &deviceStream{interfaceName: "org.astarte.Test", path: "/value"},
+			want:    "grafana-realm-org.astarte.Test/value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := streamRoomName(tt.channel); got != tt.want {
+				t.Errorf("streamRoomName(%+v) = %q, want %q", tt.channel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameFromEventUsesPrecomputedMemberOrder(t *testing.T) {
+	channel := &deviceStream{
+		path:      "/sensors",
+		aggregate: true,
+		members: []streamMember{
+			{name: "humidity", mappingType: interfaces.TypeDouble},
+			{name: "temperature", mappingType: interfaces.TypeDouble},
+		},
+	}
+
+	event := roomsEvent{Value: map[string]interface{}{"temperature": 21.5, "humidity": 42.0}}
+
+	for i := 0; i < 5; i++ {
+		frame, err := frameFromEvent(channel, event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(frame.Fields) != 3 {
+			t.Fatalf("expected Time + 2 members, got %d fields", len(frame.Fields))
+		}
+		if frame.Fields[1].Name != "humidity" || frame.Fields[2].Name != "temperature" {
+			t.Fatalf("field order changed between calls: %s, %s", frame.Fields[1].Name, frame.Fields[2].Name)
+		}
+	}
+}
+
+func TestFrameFromEventScalar(t *testing.T) {
+	channel := &deviceStream{path: "/value", valueType: interfaces.TypeInteger}
+	event := roomsEvent{Value: float64(42)}
+
+	frame, err := frameFromEvent(channel, event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(frame.Fields) != 2 {
+		t.Fatalf("expected Time + Value fields, got %d", len(frame.Fields))
+	}
+	if got := frame.Fields[1].At(0).(int64); got != 42 {
+		t.Errorf("got %v, want 42", got)
+	}
+}